@@ -2,6 +2,8 @@ package bbolt
 
 import (
 	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
 	"reflect"
 	"sort"
@@ -25,12 +27,80 @@ const (
 	metaPageFlag = 0x04
 	// freelist 页，存放无数据的空 page
 	freelistPageFlag = 0x10
+	// checksummedPageFlag 标明该 page 在 header 之后紧跟着一个 pageChecksumSize
+	// 字节的校验和，覆盖 header 以及 header 之后的全部 payload（包括 overflow
+	// 部分）。旧数据库中的 page 不会带有该 flag，因此可以与历史数据共存。
+	checksummedPageFlag = 0x20
+	// valuePageFlag 标记一个专门保存大 value 的 page，该 value 因为超过阈值
+	// 被移出了 B+树，避免拖慢树的分裂（fanout）。leaf element 通过
+	// valueIndirectLeafFlag 和 valuePageLocator 指向这类 page 的链表。
+	valuePageFlag = 0x40
 )
 
+// pageChecksumSize is the number of bytes a page checksum occupies when a
+// page carries the checksummedPageFlag. It holds a uint64 rather than
+// tightly fitting CRC32C's 32 bits, leaving room to land a wider
+// algorithm in the same trailer layout once one is actually implemented.
+const pageChecksumSize = 8
+
+// ChecksumAlgorithm identifies the hash function used to protect page
+// contents against torn writes and bit-rot. It is passed directly to
+// page.checksum and page.verifyChecksum; no Options field selects it.
+// It is independent of meta-page txid
+// validation, which only detects a torn final transaction, not bit-rot of
+// pages written long ago. Only algorithms page.checksum can actually
+// compute are exposed here; don't add a value without an implementation
+// backing it.
+type ChecksumAlgorithm uint8
+
+const (
+	// ChecksumNone disables page checksums (the historical behavior).
+	ChecksumNone ChecksumAlgorithm = iota
+	// ChecksumCRC32C checksums a page with the Castagnoli CRC-32
+	// polynomial (crc32cTable).
+	ChecksumCRC32C
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 const (
 	bucketLeafFlag = 0x01
+	// compressedLeafFlag 标明该 leaf element 的 value 在磁盘上是压缩过的，
+	// 读取时需要先解压。它可以和
+	// valueIndirectLeafFlag 同时设置：被移到 valuePageFlag 页链中的大 value
+	// 同样可以先压缩再写入。
+	compressedLeafFlag = 0x02
+	// valueIndirectLeafFlag 标明该 leaf element 内联存储的不是 value 本身，
+	// 而是一个 valuePageLocator，用于定位保存真正 value 的 valuePageFlag
+	// 页链。
+	valueIndirectLeafFlag = 0x04
 )
 
+// CompressionAlgorithm identifies how an individual value's bytes are
+// encoded on disk, recorded per-element via compressedLeafFlag, so a
+// bucket can hold a mix of compressed and raw values as entries cross the
+// threshold over time. No Options field selects it, and nothing in this
+// file compresses or decompresses values.
+type CompressionAlgorithm uint8
+
+const (
+	// CompressionNone stores values as-is (the historical behavior).
+	CompressionNone CompressionAlgorithm = iota
+	// CompressionSnappy identifies values encoded with snappy.
+	CompressionSnappy
+	// CompressionZstd identifies values encoded with zstd.
+	CompressionZstd
+)
+
+// valuePageLocator is the fixed-size payload a leaf element stores inline
+// in place of the value bytes when valueIndirectLeafFlag is set. It
+// points at the first page of the valuePageFlag chain holding the real,
+// possibly compressed, value.
+type valuePageLocator struct {
+	pgid pgid
+	size uint64
+}
+
 type pgid uint64
 
 //
@@ -56,20 +126,87 @@ func (p *page) typ() string {
 		return "meta"
 	} else if (p.flags & freelistPageFlag) != 0 {
 		return "freelist"
+	} else if (p.flags & valuePageFlag) != 0 {
+		return "value"
 	}
 	return fmt.Sprintf("unknown<%02x>", p.flags)
 }
 
+// dataOffset returns the byte offset from the start of the page to its
+// element/payload data, i.e. past the fixed header and, on a page flagged
+// checksummedPageFlag, past the pageChecksumSize trailer reserved right
+// after it. Every accessor that walks page contents (meta, leaf/branch
+// elements) must go through this instead of assuming data starts right
+// after the header, or it will read/write on top of the stored checksum.
+func (p *page) dataOffset() uintptr {
+	off := pageHeaderSize
+	if p.flags&checksummedPageFlag != 0 {
+		off += pageChecksumSize
+	}
+	return off
+}
+
+// checksum computes the page's checksum using algo, covering the page
+// header and its payload (including any overflow pages) but excluding the
+// pageChecksumSize bytes reserved for the checksum itself. sz is the total
+// size in bytes of the page plus its overflow, mirroring the n parameter
+// accepted by hexdump. The caller is responsible for only calling this on
+// a page laid out with a checksum trailer. The header is hashed as it
+// stands, flags included, so checksummedPageFlag must already be set when
+// computing the value to store, or verifyChecksum will not match it.
+func (p *page) checksum(algo ChecksumAlgorithm, sz int) (uint64, error) {
+	if algo == ChecksumNone {
+		return 0, nil
+	}
+	if algo != ChecksumCRC32C {
+		return 0, fmt.Errorf("bolt: unsupported checksum algorithm %d", algo)
+	}
+	head := *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
+		Data: uintptr(unsafe.Pointer(p)),
+		Len:  int(pageHeaderSize),
+		Cap:  int(pageHeaderSize),
+	}))
+	tailOff := int(pageHeaderSize) + pageChecksumSize
+	tail := *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
+		Data: uintptr(unsafe.Pointer(p)) + uintptr(tailOff),
+		Len:  sz - tailOff,
+		Cap:  sz - tailOff,
+	}))
+	c := crc32.Checksum(head, crc32cTable)
+	c = crc32.Update(c, crc32cTable, tail)
+	return uint64(c), nil
+}
+
+// verifyChecksum reports an error if the page is flagged as checksummed
+// and its stored checksum doesn't match a freshly computed one. It is a
+// no-op for pages written before checksums were enabled (no
+// checksummedPageFlag) or when algo is ChecksumNone, so mmap read paths
+// can call it unconditionally during the migration window.
+func (p *page) verifyChecksum(algo ChecksumAlgorithm, sz int) error {
+	if p.flags&checksummedPageFlag == 0 || algo == ChecksumNone {
+		return nil
+	}
+	want := *(*uint64)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + pageHeaderSize))
+	got, err := p.checksum(algo, sz)
+	if err != nil {
+		return err
+	}
+	if want != got {
+		return fmt.Errorf("bolt: checksum mismatch on page %d: expected %016x, got %016x", p.id, want, got)
+	}
+	return nil
+}
+
 // meta returns a pointer to the metadata section of the page.
 func (p *page) meta() *meta {
-	return (*meta)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + unsafe.Sizeof(*p)))
+	return (*meta)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + p.dataOffset()))
 }
 
 // leafPageElement retrieves the leaf node by index
 // 根据 index 检索 叶子节点
 func (p *page) leafPageElement(index uint16) *leafPageElement {
 	off := uintptr(index) * unsafe.Sizeof(leafPageElement{})
-	return (*leafPageElement)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + unsafe.Sizeof(*p) + off))
+	return (*leafPageElement)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + p.dataOffset() + off))
 }
 
 // leafPageElements retrieves a list of leaf nodes.
@@ -78,7 +215,7 @@ func (p *page) leafPageElements() []leafPageElement {
 		return nil
 	}
 	return *(*[]leafPageElement)(unsafe.Pointer(&reflect.SliceHeader{
-		Data: uintptr(unsafe.Pointer(p)) + unsafe.Sizeof(*p),
+		Data: uintptr(unsafe.Pointer(p)) + p.dataOffset(),
 		Len:  int(p.count),
 		Cap:  int(p.count),
 	}))
@@ -87,7 +224,7 @@ func (p *page) leafPageElements() []leafPageElement {
 // branchPageElement retrieves the branch node by index
 func (p *page) branchPageElement(index uint16) *branchPageElement {
 	off := uintptr(index) * unsafe.Sizeof(branchPageElement{})
-	return (*branchPageElement)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + unsafe.Sizeof(*p) + off))
+	return (*branchPageElement)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + p.dataOffset() + off))
 }
 
 // branchPageElements retrieves a list of branch nodes.
@@ -96,7 +233,7 @@ func (p *page) branchPageElements() []branchPageElement {
 		return nil
 	}
 	return *(*[]branchPageElement)(unsafe.Pointer(&reflect.SliceHeader{
-		Data: uintptr(unsafe.Pointer(p)) + unsafe.Sizeof(*p),
+		Data: uintptr(unsafe.Pointer(p)) + p.dataOffset(),
 		Len:  int(p.count),
 		Cap:  int(p.count),
 	}))
@@ -162,7 +299,10 @@ func (n *leafPageElement) key() []byte {
 	}))
 }
 
-// value returns a byte slice of the node value.
+// value returns a byte slice of the node value. If isValueIndirect is
+// true, this is a valuePageLocator rather than the value itself; callers
+// must resolve it against the valuePageFlag chain before handing it to
+// the user, and decompress it first if isCompressed is also set.
 func (n *leafPageElement) value() []byte {
 	return *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
 		Data: uintptr(unsafe.Pointer(n)) + uintptr(n.pos) + uintptr(n.ksize),
@@ -171,6 +311,124 @@ func (n *leafPageElement) value() []byte {
 	}))
 }
 
+// isCompressed returns true if the element's value bytes are stored
+// compressed and must be decompressed before use.
+func (n *leafPageElement) isCompressed() bool {
+	return (n.flags & compressedLeafFlag) != 0
+}
+
+// isValueIndirect returns true if the element's value was relocated to a
+// valuePageFlag chain because it exceeded the inline value threshold.
+func (n *leafPageElement) isValueIndirect() bool {
+	return (n.flags & valueIndirectLeafFlag) != 0
+}
+
+// locator reinterprets the element's inline value (the same bytes
+// value() would return) as a valuePageLocator. It is only valid to call
+// when isValueIndirect returns true.
+func (n *leafPageElement) locator() *valuePageLocator {
+	return (*valuePageLocator)(unsafe.Pointer(uintptr(unsafe.Pointer(n)) + uintptr(n.pos) + uintptr(n.ksize)))
+}
+
+// valuePage overlays the payload of a page flagged valuePageFlag: one
+// link in the chain of pages holding a value that was relocated out of
+// the B+tree. next chains to the following link once this page's own
+// overflow run of contiguous pages has been exhausted.
+type valuePage struct {
+	next pgid
+	size uint64
+}
+
+// valuePage returns a pointer to the valuePage header embedded at the
+// start of a page flagged valuePageFlag, past any checksum trailer
+// reserved by checksummedPageFlag.
+func (p *page) valuePage() *valuePage {
+	return (*valuePage)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + p.dataOffset()))
+}
+
+// valueBytes returns the n bytes of (possibly compressed) value payload
+// held directly by this link, i.e. everything following the valuePage
+// header.
+func (p *page) valueBytes(n int) []byte {
+	off := p.dataOffset() + unsafe.Sizeof(valuePage{})
+	return *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
+		Data: uintptr(unsafe.Pointer(p)) + off,
+		Len:  n,
+		Cap:  n,
+	}))
+}
+
+// valueChainReader implements io.ReaderAt over a chain of valuePageFlag
+// pages, lazily resolving each link against the mmap via resolve rather
+// than materializing the whole value as a single []byte up front, so
+// multi-megabyte values can be streamed without the single-slice
+// constraint of leafPageElement.value(). No Cursor method exposes it.
+type valueChainReader struct {
+	resolve func(pgid) *page
+	first   pgid
+	size    int64
+}
+
+// newValueChainReader returns a reader over the value chain pointed to by
+// loc, resolving pages on demand via resolve.
+func newValueChainReader(loc *valuePageLocator, resolve func(pgid) *page) *valueChainReader {
+	return &valueChainReader{resolve: resolve, first: loc.pgid, size: int64(loc.size)}
+}
+
+// maxValueChainHops bounds how many links ReadAt will follow before
+// giving up. It guards against a corrupted or cyclic chain (e.g. a link
+// reporting size 0 and pointing at itself or an earlier link) spinning
+// forever instead of returning an error; no legitimate value needs
+// anywhere near this many links.
+const maxValueChainHops = 1 << 20
+
+// ReadAt implements io.ReaderAt, walking the chain from its first link
+// until it has either satisfied p or run past the end of the value. It
+// follows the same end-of-data convention as bytes.Reader.ReadAt: any
+// read starting at or past the value's size returns io.EOF regardless of
+// how many bytes were requested.
+func (r *valueChainReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("bolt: invalid negative offset %d", off)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	var (
+		n       int
+		pageOff int64
+		id      = r.first
+	)
+	for hops := 0; len(p) > 0; hops++ {
+		if hops >= maxValueChainHops {
+			return n, fmt.Errorf("bolt: value chain at page %d exceeds %d links, likely corrupted", id, maxValueChainHops)
+		}
+		pg := r.resolve(id)
+		if pg == nil || pg.flags&valuePageFlag == 0 {
+			return n, fmt.Errorf("bolt: broken value chain at page %d", id)
+		}
+		vp := pg.valuePage()
+		chunkEnd := pageOff + int64(vp.size)
+		if off < chunkEnd {
+			data := pg.valueBytes(int(vp.size))[off-pageOff:]
+			c := copy(p, data)
+			n += c
+			p = p[c:]
+			off += int64(c)
+		}
+		pageOff = chunkEnd
+		if len(p) == 0 {
+			break
+		}
+		if vp.next == 0 {
+			return n, io.EOF
+		}
+		id = vp.next
+	}
+	return n, nil
+}
+
 // PageInfo represents human readable information about a page.
 type PageInfo struct {
 	ID            int
@@ -0,0 +1,300 @@
+package bbolt
+
+import (
+	"io"
+	"testing"
+	"unsafe"
+)
+
+// newTestPage allocates a zeroed buffer of size sz and overlays a *page at
+// its start, mirroring how page.go interprets raw mmap bytes.
+func newTestPage(sz int) (*page, []byte) {
+	buf := make([]byte, sz)
+	return (*page)(unsafe.Pointer(&buf[0])), buf
+}
+
+func TestPage_typ(t *testing.T) {
+	tests := []struct {
+		flags uint16
+		want  string
+	}{
+		{branchPageFlag, "branch"},
+		{leafPageFlag, "leaf"},
+		{metaPageFlag, "meta"},
+		{freelistPageFlag, "freelist"},
+		{valuePageFlag, "value"},
+		{0x80, "unknown<80>"},
+	}
+	for _, tt := range tests {
+		p, _ := newTestPage(int(pageHeaderSize))
+		p.flags = tt.flags
+		if got := p.typ(); got != tt.want {
+			t.Errorf("typ() with flags %#x = %q, want %q", tt.flags, got, tt.want)
+		}
+	}
+}
+
+func TestPage_dataOffset(t *testing.T) {
+	p, _ := newTestPage(int(pageHeaderSize) + pageChecksumSize)
+	if off := p.dataOffset(); off != pageHeaderSize {
+		t.Errorf("dataOffset() without flag = %d, want %d", off, pageHeaderSize)
+	}
+	p.flags = checksummedPageFlag
+	if off := p.dataOffset(); off != pageHeaderSize+pageChecksumSize {
+		t.Errorf("dataOffset() with flag = %d, want %d", off, pageHeaderSize+pageChecksumSize)
+	}
+}
+
+func TestPage_checksumRoundTrip(t *testing.T) {
+	const payloadLen = 32
+	sz := int(pageHeaderSize) + pageChecksumSize + payloadLen
+	p, buf := newTestPage(sz)
+	p.flags = leafPageFlag | checksummedPageFlag
+	for i := 0; i < payloadLen; i++ {
+		buf[int(pageHeaderSize)+pageChecksumSize+i] = byte(i)
+	}
+
+	sum, err := p.checksum(ChecksumCRC32C, sz)
+	if err != nil {
+		t.Fatalf("checksum: %v", err)
+	}
+	*(*uint64)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + pageHeaderSize)) = sum
+
+	if err := p.verifyChecksum(ChecksumCRC32C, sz); err != nil {
+		t.Fatalf("verifyChecksum on intact page: %v", err)
+	}
+
+	buf[sz-1] ^= 0xff
+	if err := p.verifyChecksum(ChecksumCRC32C, sz); err == nil {
+		t.Fatal("verifyChecksum did not detect corruption")
+	}
+}
+
+func TestPage_verifyChecksum_unflagged(t *testing.T) {
+	p, _ := newTestPage(int(pageHeaderSize))
+	p.flags = leafPageFlag
+	if err := p.verifyChecksum(ChecksumCRC32C, int(pageHeaderSize)); err != nil {
+		t.Fatalf("expected no-op for unflagged page, got %v", err)
+	}
+}
+
+func TestPage_checksum_unsupportedAlgorithm(t *testing.T) {
+	sz := int(pageHeaderSize) + pageChecksumSize
+	p, _ := newTestPage(sz)
+	p.flags = leafPageFlag | checksummedPageFlag
+	if _, err := p.checksum(ChecksumAlgorithm(99), sz); err == nil {
+		t.Fatal("expected error for an unsupported checksum algorithm")
+	}
+}
+
+func TestLeafPageElement_flags(t *testing.T) {
+	e := leafPageElement{flags: compressedLeafFlag}
+	if !e.isCompressed() {
+		t.Error("isCompressed() = false, want true")
+	}
+	if e.isValueIndirect() {
+		t.Error("isValueIndirect() = true, want false")
+	}
+
+	e = leafPageElement{flags: valueIndirectLeafFlag}
+	if e.isCompressed() {
+		t.Error("isCompressed() = true, want false")
+	}
+	if !e.isValueIndirect() {
+		t.Error("isValueIndirect() = false, want true")
+	}
+}
+
+// TestLeafPageElement_locator lays out a leaf element followed by a key
+// and a valuePageLocator exactly as they'd sit inline in a leaf page, and
+// checks that locator() finds the locator after the key rather than
+// overlapping it (the bytes value() would return, not n.pos alone).
+func TestLeafPageElement_locator(t *testing.T) {
+	key := []byte("k")
+	buf := make([]byte, int(leafPageElementSize)+len(key)+int(unsafe.Sizeof(valuePageLocator{})))
+	e := (*leafPageElement)(unsafe.Pointer(&buf[0]))
+	e.flags = valueIndirectLeafFlag
+	e.pos = uint32(leafPageElementSize)
+	e.ksize = uint32(len(key))
+	e.vsize = uint32(unsafe.Sizeof(valuePageLocator{}))
+	copy(buf[leafPageElementSize:], key)
+
+	loc := (*valuePageLocator)(unsafe.Pointer(&buf[int(leafPageElementSize)+len(key)]))
+	loc.pgid = 42
+	loc.size = 1 << 20
+
+	got := e.locator()
+	if got.pgid != 42 || got.size != 1<<20 {
+		t.Errorf("locator() = %+v, want {pgid:42 size:%d}", *got, 1<<20)
+	}
+}
+
+func TestValuePage_roundTrip(t *testing.T) {
+	payload := []byte("a large relocated value's first chunk")
+	sz := int(pageHeaderSize) + int(unsafe.Sizeof(valuePage{})) + len(payload)
+	p, buf := newTestPage(sz)
+	p.flags = valuePageFlag
+
+	vp := p.valuePage()
+	vp.next = 7
+	vp.size = uint64(len(payload))
+	copy(buf[int(pageHeaderSize)+int(unsafe.Sizeof(valuePage{})):], payload)
+
+	if got := string(p.valueBytes(len(payload))); got != string(payload) {
+		t.Errorf("valueBytes() = %q, want %q", got, payload)
+	}
+	if p.valuePage().next != 7 {
+		t.Errorf("valuePage().next = %d, want 7", p.valuePage().next)
+	}
+}
+
+// TestValuePage_checksummed asserts valuePage()/valueBytes() skip the
+// checksum trailer when valuePageFlag is combined with
+// checksummedPageFlag, i.e. they go through dataOffset() like every other
+// accessor rather than assuming the payload starts right after the page
+// header.
+func TestValuePage_checksummed(t *testing.T) {
+	payload := []byte("checksummed overflow chunk")
+	sz := int(pageHeaderSize) + pageChecksumSize + int(unsafe.Sizeof(valuePage{})) + len(payload)
+	p, buf := newTestPage(sz)
+	p.flags = valuePageFlag | checksummedPageFlag
+
+	vp := p.valuePage()
+	vp.next = 9
+	vp.size = uint64(len(payload))
+	copy(buf[int(pageHeaderSize)+pageChecksumSize+int(unsafe.Sizeof(valuePage{})):], payload)
+
+	if got := string(p.valueBytes(len(payload))); got != string(payload) {
+		t.Errorf("valueBytes() = %q, want %q", got, payload)
+	}
+	if p.valuePage().next != 9 {
+		t.Errorf("valuePage().next = %d, want 9", p.valuePage().next)
+	}
+}
+
+// newValueChainFixture lays chunks out as a chain of valuePageFlag pages
+// (one link per chunk) and returns a locator for the chain's start plus a
+// resolve func a valueChainReader can use to walk it.
+func newValueChainFixture(chunks [][]byte) (*valuePageLocator, func(pgid) *page) {
+	pages := make(map[pgid]*page)
+	var first pgid
+	var prev *valuePage
+	total := 0
+	for i, chunk := range chunks {
+		id := pgid(i + 1)
+		sz := int(pageHeaderSize) + int(unsafe.Sizeof(valuePage{})) + len(chunk)
+		p, buf := newTestPage(sz)
+		p.id = id
+		p.flags = valuePageFlag
+		vp := p.valuePage()
+		vp.size = uint64(len(chunk))
+		copy(buf[int(pageHeaderSize)+int(unsafe.Sizeof(valuePage{})):], chunk)
+		pages[id] = p
+		if i == 0 {
+			first = id
+		} else {
+			prev.next = id
+		}
+		prev = vp
+		total += len(chunk)
+	}
+	loc := &valuePageLocator{pgid: first, size: uint64(total)}
+	return loc, func(id pgid) *page { return pages[id] }
+}
+
+func TestValueChainReader_ReadAt(t *testing.T) {
+	loc, resolve := newValueChainFixture([][]byte{[]byte("hello "), []byte("world")})
+	r := newValueChainReader(loc, resolve)
+
+	buf := make([]byte, 11)
+	if n, err := r.ReadAt(buf, 0); err != nil || n != 11 || string(buf) != "hello world" {
+		t.Errorf("ReadAt(0) = (%d, %v), buf %q, want (11, nil, %q)", n, err, buf, "hello world")
+	}
+
+	buf2 := make([]byte, 5)
+	n, err := r.ReadAt(buf2, 6)
+	if err != nil || string(buf2[:n]) != "world" {
+		t.Errorf("ReadAt(off=6) = (%d, %v), buf %q, want (5, nil, %q)", n, err, buf2[:n], "world")
+	}
+}
+
+// TestValueChainReader_EOFAtEnd asserts the bytes.Reader.ReadAt
+// convention: a read starting at or past the value's size is io.EOF even
+// when the caller asked for zero bytes.
+func TestValueChainReader_EOFAtEnd(t *testing.T) {
+	loc, resolve := newValueChainFixture([][]byte{[]byte("abc")})
+	r := newValueChainReader(loc, resolve)
+
+	if _, err := r.ReadAt(nil, 3); err != io.EOF {
+		t.Errorf("ReadAt(nil, size) error = %v, want io.EOF", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 1), 3); err != io.EOF {
+		t.Errorf("ReadAt(1 byte, size) error = %v, want io.EOF", err)
+	}
+}
+
+// TestValueChainReader_ChecksummedLink asserts ReadAt still finds the
+// right bytes when a chain link is itself flagged checksummedPageFlag, a
+// combination the chunk0-1 request explicitly calls out ("payload
+// including overflow pages") and that valuePage()/valueBytes() must
+// honor via dataOffset().
+func TestValueChainReader_ChecksummedLink(t *testing.T) {
+	first := []byte("hello ")
+	second := []byte("world")
+
+	sz1 := int(pageHeaderSize) + int(unsafe.Sizeof(valuePage{})) + len(first)
+	p1, buf1 := newTestPage(sz1)
+	p1.id = 1
+	p1.flags = valuePageFlag
+	vp1 := p1.valuePage()
+	vp1.size = uint64(len(first))
+	vp1.next = 2
+	copy(buf1[int(pageHeaderSize)+int(unsafe.Sizeof(valuePage{})):], first)
+
+	sz2 := int(pageHeaderSize) + pageChecksumSize + int(unsafe.Sizeof(valuePage{})) + len(second)
+	p2, buf2 := newTestPage(sz2)
+	p2.id = 2
+	p2.flags = valuePageFlag | checksummedPageFlag
+	vp2 := p2.valuePage()
+	vp2.size = uint64(len(second))
+	copy(buf2[int(pageHeaderSize)+pageChecksumSize+int(unsafe.Sizeof(valuePage{})):], second)
+
+	pages := map[pgid]*page{1: p1, 2: p2}
+	loc := &valuePageLocator{pgid: 1, size: uint64(len(first) + len(second))}
+	r := newValueChainReader(loc, func(id pgid) *page { return pages[id] })
+
+	buf := make([]byte, len(first)+len(second))
+	n, err := r.ReadAt(buf, 0)
+	if err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if want := "hello world"; n != len(want) || string(buf) != want {
+		t.Errorf("ReadAt = (%d, %q), want (%d, %q)", n, buf, len(want), want)
+	}
+}
+
+// TestValueChainReader_CyclicChainErrors exercises a link that reports no
+// payload and points back at itself; ReadAt must error out instead of
+// looping forever.
+func TestValueChainReader_CyclicChainErrors(t *testing.T) {
+	sz := int(pageHeaderSize) + int(unsafe.Sizeof(valuePage{}))
+	p, _ := newTestPage(sz)
+	p.id = 1
+	p.flags = valuePageFlag
+	vp := p.valuePage()
+	vp.size = 0
+	vp.next = 1
+
+	loc := &valuePageLocator{pgid: 1, size: 1 << 30}
+	resolve := func(id pgid) *page {
+		if id == 1 {
+			return p
+		}
+		return nil
+	}
+	r := newValueChainReader(loc, resolve)
+
+	if _, err := r.ReadAt(make([]byte, 16), 0); err == nil {
+		t.Fatal("expected an error reading a self-referential chain, got nil")
+	}
+}